@@ -0,0 +1,109 @@
+/*
+Copyright 2018 Heptio Inc.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package aggregation
+
+import (
+	"fmt"
+	"time"
+
+	"github.com/sirupsen/logrus"
+	v1 "k8s.io/api/core/v1"
+	"k8s.io/client-go/kubernetes"
+	typedcorev1 "k8s.io/client-go/kubernetes/typed/core/v1"
+	"k8s.io/client-go/kubernetes/scheme"
+	"k8s.io/client-go/tools/record"
+)
+
+const (
+	// ReasonPluginRunning is the Event reason emitted when a plugin starts running.
+	ReasonPluginRunning = "PluginRunning"
+	// ReasonPluginComplete is the Event reason emitted when a plugin finishes successfully.
+	ReasonPluginComplete = "PluginComplete"
+	// ReasonPluginFailed is the Event reason emitted when a plugin finishes with an error.
+	ReasonPluginFailed = "PluginFailed"
+
+	// resultTypeAnnotationKey tags each plugin status Event with the plugin's
+	// result type so `kubectl get events -o json` consumers can filter on it
+	// without parsing the message.
+	resultTypeAnnotationKey = "sonobuoy.hept.io/result-type"
+
+	eventComponent = "sonobuoy-aggregator"
+)
+
+// NewEventRecorder builds an EventRecorder that publishes Events against
+// objects in namespace, along with a stop func that must be called once the
+// recorder is no longer needed to flush and release the underlying
+// broadcaster.
+func NewEventRecorder(client kubernetes.Interface, namespace string) (record.EventRecorder, func()) {
+	broadcaster := record.NewBroadcaster()
+	watcher := broadcaster.StartRecordingToSink(&typedcorev1.EventSinkImpl{
+		Interface: client.CoreV1().Events(namespace),
+	})
+	broadcaster.StartLogging(logrus.Infof)
+
+	recorder := broadcaster.NewRecorder(scheme.Scheme, v1.EventSource{Component: eventComponent})
+	return recorder, watcher.Stop
+}
+
+// recordTransition emits a structured Event for a single plugin's state
+// change against the aggregator pod. Errors resolving the involved object are
+// logged rather than returned, since a missing Event is not worth failing the
+// status update over.
+func (u *updater) recordTransition(from, to string, status *PluginStatus, duration time.Duration) {
+	pod, err := u.involvedPod()
+	if err != nil {
+		logrus.WithError(err).Warning("couldn't resolve aggregator pod for status event")
+		return
+	}
+
+	reason := reasonForStatus(to)
+	eventType := v1.EventTypeNormal
+	if to == FailedStatus {
+		eventType = v1.EventTypeWarning
+	}
+
+	message := fmt.Sprintf("plugin %q on node %q transitioned %s -> %s after %s", status.Plugin, status.Node, from, to, duration.Round(time.Second))
+	annotations := map[string]string{resultTypeAnnotationKey: status.Plugin}
+
+	u.recorder.AnnotatedEventf(pod, annotations, eventType, reason, message)
+}
+
+func reasonForStatus(status string) string {
+	switch status {
+	case CompleteStatus:
+		return ReasonPluginComplete
+	case FailedStatus:
+		return ReasonPluginFailed
+	default:
+		return ReasonPluginRunning
+	}
+}
+
+// involvedPod lazily resolves and caches the aggregator pod Events get
+// recorded against.
+func (u *updater) involvedPod() (*v1.Pod, error) {
+	if u.pod != nil {
+		return u.pod, nil
+	}
+
+	pod, err := GetAggregatorPod(u.client, u.namespace)
+	if err != nil {
+		return nil, err
+	}
+	u.pod = pod
+	return u.pod, nil
+}
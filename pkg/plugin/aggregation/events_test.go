@@ -0,0 +1,73 @@
+/*
+Copyright 2018 Heptio Inc.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package aggregation
+
+import (
+	"strings"
+	"testing"
+
+	v1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/client-go/kubernetes/fake"
+	"k8s.io/client-go/tools/record"
+
+	"github.com/heptio/sonobuoy/pkg/plugin"
+)
+
+// TestUpdaterWithRecorderEmitsEvent checks that a plugin transition recorded
+// through newUpdaterWithRecorder produces a PluginComplete Event. The
+// recorder-less newUpdater is covered by TestUpdaterWithoutRecorderDoesNotEmit.
+func TestUpdaterWithRecorderEmitsEvent(t *testing.T) {
+	namespace := "sonobuoy"
+	client := fake.NewSimpleClientset(&v1.Pod{
+		ObjectMeta: metav1.ObjectMeta{
+			Name:      DefaultStatusPodName,
+			Namespace: namespace,
+			Labels:    map[string]string{"run": "sonobuoy-master"},
+		},
+	})
+
+	recorder := record.NewFakeRecorder(10)
+	expected := []plugin.ExpectedResult{{NodeName: "node1", ResultType: "e2e"}}
+	u := newUpdaterWithRecorder(expected, namespace, client, nil, recorder)
+
+	if err := u.Receive(&PluginStatus{Node: "node1", Plugin: "e2e", Status: CompleteStatus}); err != nil {
+		t.Fatalf("Receive returned error: %v", err)
+	}
+
+	select {
+	case msg := <-recorder.Events:
+		if !strings.Contains(msg, ReasonPluginComplete) {
+			t.Errorf("event %q doesn't mention reason %q", msg, ReasonPluginComplete)
+		}
+	default:
+		t.Fatal("expected an Event to be recorded, got none")
+	}
+}
+
+// TestUpdaterWithoutRecorderDoesNotEmit ensures the default newUpdater, which
+// has no recorder, still works (and doesn't nil-pointer panic on transitions).
+func TestUpdaterWithoutRecorderDoesNotEmit(t *testing.T) {
+	namespace := "sonobuoy"
+	client := fake.NewSimpleClientset()
+	expected := []plugin.ExpectedResult{{NodeName: "node1", ResultType: "e2e"}}
+	u := newUpdater(expected, namespace, client, nil)
+
+	if err := u.Receive(&PluginStatus{Node: "node1", Plugin: "e2e", Status: CompleteStatus}); err != nil {
+		t.Fatalf("Receive returned error: %v", err)
+	}
+}
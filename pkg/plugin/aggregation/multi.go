@@ -0,0 +1,126 @@
+/*
+Copyright 2018 Heptio Inc.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package aggregation
+
+import (
+	"encoding/json"
+	"fmt"
+	"sync"
+
+	"github.com/pkg/errors"
+	"k8s.io/client-go/kubernetes"
+
+	"github.com/heptio/sonobuoy/pkg/plugin"
+)
+
+// ClusterTarget names one cluster a multi-cluster run fans out to: the
+// kubeconfig context it came from, the client built against that context, and
+// the namespace Sonobuoy is running in there.
+type ClusterTarget struct {
+	Name      string
+	Namespace string
+	Client    kubernetes.Interface
+}
+
+// MultiUpdater coordinates a Sonobuoy run spanning several clusters. It holds
+// one updater per cluster and presents the same Receive/Annotate/Serialize
+// surface as updater, merging every cluster's Status into a single top-level
+// Status keyed by cluster name.
+//
+// `sonobuoy status`, `retrieve`, and `wait` are meant to aggregate across
+// clusters on top of this and lay retrieved results out as
+// results/<cluster>/..., but those CLI commands live in cmd/sonobuoy, which
+// isn't part of this source tree, so that wiring is still outstanding.
+type MultiUpdater struct {
+	sync.RWMutex
+	updaters map[string]*updater
+}
+
+// newMultiUpdater creates a MultiUpdater with one updater per cluster, all
+// expecting the same set of plugin results.
+func newMultiUpdater(clusters []ClusterTarget, expected []plugin.ExpectedResult) *MultiUpdater {
+	m := &MultiUpdater{
+		updaters: make(map[string]*updater, len(clusters)),
+	}
+
+	for _, c := range clusters {
+		m.updaters[c.Name] = newUpdater(expected, c.Namespace, c.Client, nil)
+	}
+
+	return m
+}
+
+// Receive routes update to the updater for update.Cluster and updates that
+// plugin's status.
+func (m *MultiUpdater) Receive(update *PluginStatus) error {
+	m.RLock()
+	u, ok := m.updaters[update.Cluster]
+	m.RUnlock()
+	if !ok {
+		return fmt.Errorf("couldn't find updater for cluster %q", update.Cluster)
+	}
+	return u.Receive(update)
+}
+
+// Annotate updates status from results, keyed first by cluster name and then
+// by the same plugin-result key Annotate uses for a single cluster, then
+// publishes each cluster's status through its own StatusSink.
+func (m *MultiUpdater) Annotate(results map[string]map[string]*plugin.Result) error {
+	m.RLock()
+	defer m.RUnlock()
+
+	var errs []string
+	for clusterName, clusterResults := range results {
+		u, ok := m.updaters[clusterName]
+		if !ok {
+			errs = append(errs, fmt.Sprintf("no updater for cluster %q", clusterName))
+			continue
+		}
+		if err := u.Annotate(clusterResults); err != nil {
+			errs = append(errs, fmt.Sprintf("cluster %q: %v", clusterName, err))
+		}
+	}
+
+	if len(errs) > 0 {
+		return errors.Errorf("couldn't annotate all clusters: %v", errs)
+	}
+	return nil
+}
+
+// Serialize merges every cluster's Status into one, tagging each PluginStatus
+// with its Cluster, and json-encodes the result.
+func (m *MultiUpdater) Serialize() (string, error) {
+	m.RLock()
+	defer m.RUnlock()
+
+	merged := Status{Status: RunningStatus}
+	for clusterName, u := range m.updaters {
+		u.RLock()
+		for _, p := range u.status.Plugins {
+			p.Cluster = clusterName
+			merged.Plugins = append(merged.Plugins, p)
+		}
+		u.RUnlock()
+	}
+
+	if err := merged.updateStatus(); err != nil {
+		return "", errors.Wrap(err, "couldn't compute merged status")
+	}
+
+	bytes, err := json.Marshal(merged)
+	return string(bytes), errors.Wrap(err, "couldn't marshal merged status")
+}
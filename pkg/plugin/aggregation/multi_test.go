@@ -0,0 +1,68 @@
+/*
+Copyright 2018 Heptio Inc.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package aggregation
+
+import (
+	"encoding/json"
+	"testing"
+
+	"k8s.io/client-go/kubernetes/fake"
+
+	"github.com/heptio/sonobuoy/pkg/plugin"
+)
+
+// TestMultiUpdaterReceive ensures a status update for a plugin name/node that
+// exists identically in more than one cluster is routed to, and recorded
+// against, only the cluster it actually came from.
+func TestMultiUpdaterReceive(t *testing.T) {
+	expected := []plugin.ExpectedResult{{NodeName: "node1", ResultType: "e2e"}}
+	m := newMultiUpdater([]ClusterTarget{
+		{Name: "cluster-a", Namespace: "sonobuoy", Client: fake.NewSimpleClientset()},
+		{Name: "cluster-b", Namespace: "sonobuoy", Client: fake.NewSimpleClientset()},
+	}, expected)
+
+	if err := m.Receive(&PluginStatus{Node: "node1", Plugin: "e2e", Cluster: "cluster-b", Status: CompleteStatus}); err != nil {
+		t.Fatalf("Receive returned error: %v", err)
+	}
+
+	str, err := m.Serialize()
+	if err != nil {
+		t.Fatalf("Serialize returned error: %v", err)
+	}
+
+	var merged Status
+	if err := json.Unmarshal([]byte(str), &merged); err != nil {
+		t.Fatalf("couldn't decode merged status: %v", err)
+	}
+
+	var gotA, gotB string
+	for _, p := range merged.Plugins {
+		switch p.Cluster {
+		case "cluster-a":
+			gotA = p.Status
+		case "cluster-b":
+			gotB = p.Status
+		}
+	}
+
+	if gotB != CompleteStatus {
+		t.Errorf("cluster-b plugin status = %q, want %q", gotB, CompleteStatus)
+	}
+	if gotA != RunningStatus {
+		t.Errorf("cluster-a plugin status = %q, want %q (should be untouched)", gotA, RunningStatus)
+	}
+}
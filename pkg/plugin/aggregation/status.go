@@ -0,0 +1,73 @@
+/*
+Copyright 2018 Heptio Inc.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package aggregation
+
+const (
+	// RunningStatus means one or more plugins is still running.
+	RunningStatus = "running"
+	// CompleteStatus means all plugins have finished, with none failed.
+	CompleteStatus = "complete"
+	// FailedStatus means all plugins have finished and at least one failed.
+	FailedStatus = "failed"
+)
+
+// PluginStatus represents the current status of a single plugin result.
+// Cluster is only populated for multi-cluster runs, where it disambiguates
+// identically-named plugins running against different clusters; it is empty
+// for single-cluster runs.
+type PluginStatus struct {
+	Node    string `json:"node"`
+	Plugin  string `json:"plugin"`
+	Status  string `json:"status"`
+	Cluster string `json:"cluster,omitempty"`
+}
+
+// Status represents the aggregated status of a Sonobuoy run: the rolled-up
+// Status and the per-plugin PluginStatus entries it was computed from.
+type Status struct {
+	Plugins []PluginStatus `json:"plugins"`
+	Status  string         `json:"status"`
+}
+
+// updateStatus recomputes the top-level Status from the current Plugins
+// slice: running until every plugin has finished, then complete unless any
+// plugin failed.
+func (s *Status) updateStatus() error {
+	complete := true
+	failed := false
+
+	for _, p := range s.Plugins {
+		switch p.Status {
+		case CompleteStatus:
+		case FailedStatus:
+			failed = true
+		default:
+			complete = false
+		}
+	}
+
+	switch {
+	case !complete:
+		s.Status = RunningStatus
+	case failed:
+		s.Status = FailedStatus
+	default:
+		s.Status = CompleteStatus
+	}
+
+	return nil
+}
@@ -0,0 +1,293 @@
+/*
+Copyright 2018 Heptio Inc.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package aggregation
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"time"
+
+	"github.com/pkg/errors"
+	coordinationv1 "k8s.io/api/coordination/v1"
+	v1 "k8s.io/api/core/v1"
+	apierrors "k8s.io/apimachinery/pkg/api/errors"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/client-go/kubernetes"
+)
+
+// StatusBackend selects which StatusSink implementation a run should publish
+// status through.
+type StatusBackend string
+
+const (
+	// StatusBackendAnnotation publishes status as a JSON blob in the
+	// sonobuoy.hept.io/status annotation on the aggregator pod. This is the
+	// long-standing default but is bounded by the Kubernetes annotation size
+	// limit.
+	StatusBackendAnnotation StatusBackend = "annotation"
+
+	// StatusBackendLease publishes status via a coordination.k8s.io/v1 Lease,
+	// with the status blob held in a sibling ConfigMap. It has no practical
+	// size limit and supports Watch-based consumers.
+	StatusBackendLease StatusBackend = "lease"
+
+	// LeaseConfigMapSuffix is appended to the run UUID to name the ConfigMap
+	// that holds the serialized status alongside a run's Lease.
+	LeaseConfigMapSuffix = "-status"
+
+	// StatusConfigMapKey is the ConfigMap data key the serialized status is
+	// stored under.
+	StatusConfigMapKey = "status"
+)
+
+// StatusSink is the write side of status publication. Implementations decide
+// where the serialized Status ends up (pod annotation, Lease+ConfigMap, CR,
+// ...); the updater only needs to know how to push a new snapshot.
+type StatusSink interface {
+	Publish(ctx context.Context, status Status) error
+}
+
+// NewStatusSink constructs the StatusSink for the given backend. runUID
+// identifies the run and is used to key the Lease/ConfigMap; it is ignored by
+// the annotation backend.
+func NewStatusSink(backend StatusBackend, client kubernetes.Interface, namespace, runUID string) StatusSink {
+	switch backend {
+	case StatusBackendLease:
+		return &leaseSink{client: client, namespace: namespace, runUID: runUID}
+	default:
+		return &annotationSink{client: client, namespace: namespace}
+	}
+}
+
+// annotationSink publishes status by writing the serialized Status into the
+// sonobuoy.hept.io/status annotation on the aggregator pod with a
+// Get-then-Update, retried on Conflict (see Publish).
+type annotationSink struct {
+	client    kubernetes.Interface
+	namespace string
+}
+
+const (
+	applyMaxRetries   = 5
+	applyInitialDelay = 100 * time.Millisecond
+)
+
+// Publish writes the serialized status into the sonobuoy.hept.io/status
+// annotation on the aggregator pod via read-modify-write, retrying with a
+// bounded exponential backoff when Update rejects a stale ResourceVersion.
+//
+// This was originally written against types.MergePatchType, but a merge patch
+// carries no ResourceVersion, so the apiserver has nothing to compare against
+// and two concurrent patches just overwrite one another - there's no Conflict
+// to retry on, so a patch-and-retry loop is dead code. Get-then-Update gives
+// the apiserver real optimistic concurrency: a stale ResourceVersion at Update
+// time comes back as a genuine Conflict, which is what the retry loop here is
+// actually for.
+func (a *annotationSink) Publish(ctx context.Context, status Status) error {
+	podName, err := GetAggregatorPodName(a.client, a.namespace)
+	if err != nil {
+		return errors.Wrap(err, "failed to get name of the aggregator pod to annotate")
+	}
+
+	bytes, err := json.Marshal(status)
+	if err != nil {
+		return errors.Wrap(err, "couldn't marshal status")
+	}
+
+	delay := applyInitialDelay
+	var lastErr error
+	for attempt := 0; attempt < applyMaxRetries; attempt++ {
+		pod, err := a.client.CoreV1().Pods(a.namespace).Get(podName, metav1.GetOptions{})
+		if err != nil {
+			return errors.Wrap(err, "couldn't get aggregator pod to annotate")
+		}
+
+		if pod.Annotations == nil {
+			pod.Annotations = map[string]string{}
+		}
+		pod.Annotations[StatusAnnotationName] = string(bytes)
+
+		_, err = a.client.CoreV1().Pods(a.namespace).Update(pod)
+		if err == nil {
+			return nil
+		}
+		if !apierrors.IsConflict(err) {
+			return errors.Wrap(err, "couldn't update pod annotation")
+		}
+
+		lastErr = err
+		select {
+		case <-time.After(delay):
+		case <-ctx.Done():
+			return ctx.Err()
+		}
+		delay *= 2
+	}
+
+	return errors.Wrap(lastErr, "gave up updating pod annotation after repeated conflicts")
+}
+
+// leaseSink publishes status by writing the serialized Status into a
+// ConfigMap and renewing a coordination.k8s.io/v1 Lease that points at it,
+// removing the annotation size cap entirely. The Lease is renewed alongside
+// every publish so it reflects liveness, but nothing in this package reads
+// its RenewTime/HolderIdentity back out yet - GetStatusFromLease and
+// StatusWatcher both go straight to the ConfigMap. Readers wanting a cheap
+// liveness check without fetching the status blob would need to add that
+// themselves for now.
+type leaseSink struct {
+	client    kubernetes.Interface
+	namespace string
+	runUID    string
+}
+
+func (l *leaseSink) leaseName() string {
+	return l.runUID
+}
+
+func (l *leaseSink) configMapName() string {
+	return l.runUID + LeaseConfigMapSuffix
+}
+
+func (l *leaseSink) Publish(ctx context.Context, status Status) error {
+	bytes, err := json.Marshal(status)
+	if err != nil {
+		return errors.Wrap(err, "couldn't marshal status")
+	}
+
+	if err := l.publishConfigMap(bytes); err != nil {
+		return errors.Wrap(err, "couldn't publish status configmap")
+	}
+
+	if err := l.renewLease(); err != nil {
+		return errors.Wrap(err, "couldn't renew status lease")
+	}
+
+	return nil
+}
+
+func (l *leaseSink) publishConfigMap(statusJSON []byte) error {
+	cms := l.client.CoreV1().ConfigMaps(l.namespace)
+
+	cm := &v1.ConfigMap{
+		ObjectMeta: metav1.ObjectMeta{
+			Name:      l.configMapName(),
+			Namespace: l.namespace,
+		},
+		Data: map[string]string{
+			StatusConfigMapKey: string(statusJSON),
+		},
+	}
+
+	_, err := cms.Update(cm)
+	if apierrors.IsNotFound(err) {
+		_, err = cms.Create(cm)
+	}
+	return err
+}
+
+func (l *leaseSink) renewLease() error {
+	leases := l.client.CoordinationV1().Leases(l.namespace)
+
+	now := metav1.NowMicro()
+	holder := fmt.Sprintf("sonobuoy-aggregator-%s", l.runUID)
+
+	existing, err := leases.Get(l.leaseName(), metav1.GetOptions{})
+	if apierrors.IsNotFound(err) {
+		lease := &coordinationv1.Lease{
+			ObjectMeta: metav1.ObjectMeta{
+				Name:      l.leaseName(),
+				Namespace: l.namespace,
+			},
+			Spec: coordinationv1.LeaseSpec{
+				HolderIdentity: &holder,
+				RenewTime:      &now,
+			},
+		}
+		_, err = leases.Create(lease)
+		return err
+	}
+	if err != nil {
+		return err
+	}
+
+	existing.Spec.HolderIdentity = &holder
+	existing.Spec.RenewTime = &now
+	_, err = leases.Update(existing)
+	return err
+}
+
+// GetStatus reads the current Status for backend, transparently trying the
+// Lease/ConfigMap first for StatusBackendLease (falling back to the pod
+// annotation if nothing has been published there yet) and reading the pod
+// annotation directly for StatusBackendAnnotation.
+//
+// This is the one call CLI status readers (`sonobuoy status`, `sonobuoy
+// retrieve`, `sonobuoy wait`) need to make instead of reaching into the
+// aggregator pod themselves, so a run can move between backends without every
+// caller needing to know which one is in effect. Those CLI commands aren't
+// part of this source tree, so wiring this in is still outstanding.
+func GetStatus(client kubernetes.Interface, namespace string, backend StatusBackend, runUID string) (*Status, error) {
+	if backend == StatusBackendLease {
+		return GetStatusFromLease(client, namespace, runUID)
+	}
+	return getStatusFromAnnotation(client, namespace)
+}
+
+// GetStatusFromLease reads status published via the lease backend, falling
+// back to annotation sonobuoy.hept.io/status on the aggregator pod if no
+// Lease/ConfigMap pair exists for runUID. Prefer GetStatus, which also
+// handles the StatusBackendAnnotation case.
+func GetStatusFromLease(client kubernetes.Interface, namespace, runUID string) (*Status, error) {
+	cm, err := client.CoreV1().ConfigMaps(namespace).Get(runUID+LeaseConfigMapSuffix, metav1.GetOptions{})
+	if err != nil {
+		if !apierrors.IsNotFound(err) {
+			return nil, errors.Wrap(err, "couldn't get status configmap")
+		}
+		return getStatusFromAnnotation(client, namespace)
+	}
+
+	status := &Status{}
+	if err := json.Unmarshal([]byte(cm.Data[StatusConfigMapKey]), status); err != nil {
+		return nil, errors.Wrap(err, "couldn't decode status configmap")
+	}
+	return status, nil
+}
+
+func getStatusFromAnnotation(client kubernetes.Interface, namespace string) (*Status, error) {
+	podName, err := GetAggregatorPodName(client, namespace)
+	if err != nil {
+		return nil, errors.Wrap(err, "failed to get name of the aggregator pod")
+	}
+
+	pod, err := client.CoreV1().Pods(namespace).Get(podName, metav1.GetOptions{})
+	if err != nil {
+		return nil, errors.Wrap(err, "couldn't get aggregator pod")
+	}
+
+	annotation, ok := pod.Annotations[StatusAnnotationName]
+	if !ok {
+		return nil, fmt.Errorf("aggregator pod %q has no %q annotation", podName, StatusAnnotationName)
+	}
+
+	status := &Status{}
+	if err := json.Unmarshal([]byte(annotation), status); err != nil {
+		return nil, errors.Wrap(err, "couldn't decode status annotation")
+	}
+	return status, nil
+}
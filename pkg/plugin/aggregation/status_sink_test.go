@@ -0,0 +1,195 @@
+/*
+Copyright 2018 Heptio Inc.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package aggregation
+
+import (
+	"context"
+	"encoding/json"
+	"sync"
+	"sync/atomic"
+	"testing"
+
+	v1 "k8s.io/api/core/v1"
+	apierrors "k8s.io/apimachinery/pkg/api/errors"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/runtime"
+	"k8s.io/apimachinery/pkg/runtime/schema"
+	"k8s.io/client-go/kubernetes/fake"
+	clienttesting "k8s.io/client-go/testing"
+)
+
+// TestAnnotationSinkConcurrentPublishConverges simulates two callers racing
+// to publish status at once, each hitting a Conflict on their first
+// Get-then-Update attempt (the way a real apiserver rejects a stale
+// ResourceVersion), and asserts both retry through to a consistent, decodable
+// annotation rather than clobbering each other or giving up early.
+func TestAnnotationSinkConcurrentPublishConverges(t *testing.T) {
+	namespace := "sonobuoy"
+	client := fake.NewSimpleClientset(&v1.Pod{
+		ObjectMeta: metav1.ObjectMeta{
+			Name:      DefaultStatusPodName,
+			Namespace: namespace,
+			Labels:    map[string]string{"run": "sonobuoy-master"},
+		},
+	})
+
+	var updateCount int32
+	client.PrependReactor("update", "pods", func(action clienttesting.Action) (bool, runtime.Object, error) {
+		if atomic.AddInt32(&updateCount, 1) <= 2 {
+			return true, nil, apierrors.NewConflict(schema.GroupResource{Resource: "pods"}, DefaultStatusPodName, nil)
+		}
+		return false, nil, nil
+	})
+
+	sink := &annotationSink{client: client, namespace: namespace}
+
+	var wg sync.WaitGroup
+	errs := make(chan error, 2)
+	for i := 0; i < 2; i++ {
+		wg.Add(1)
+		go func(i int) {
+			defer wg.Done()
+			status := Status{Status: RunningStatus, Plugins: []PluginStatus{{Node: "n1", Plugin: "p", Status: RunningStatus}}}
+			errs <- sink.Publish(context.Background(), status)
+		}(i)
+	}
+	wg.Wait()
+	close(errs)
+
+	for err := range errs {
+		if err != nil {
+			t.Fatalf("Publish returned error: %v", err)
+		}
+	}
+
+	pod, err := client.CoreV1().Pods(namespace).Get(DefaultStatusPodName, metav1.GetOptions{})
+	if err != nil {
+		t.Fatalf("couldn't get pod: %v", err)
+	}
+
+	annotation, ok := pod.Annotations[StatusAnnotationName]
+	if !ok {
+		t.Fatalf("pod missing %q annotation", StatusAnnotationName)
+	}
+
+	var status Status
+	if err := json.Unmarshal([]byte(annotation), &status); err != nil {
+		t.Fatalf("final annotation isn't valid status json: %v", err)
+	}
+}
+
+// TestNewStatusSinkLease checks that NewStatusSink(StatusBackendLease, ...)
+// returns a sink that publishes into the Lease/ConfigMap pair named after
+// runUID, and that the Lease it renews is held by the aggregator.
+func TestNewStatusSinkLease(t *testing.T) {
+	namespace := "sonobuoy"
+	runUID := "test-run-uid"
+	client := fake.NewSimpleClientset()
+
+	sink := NewStatusSink(StatusBackendLease, client, namespace, runUID)
+	if _, ok := sink.(*leaseSink); !ok {
+		t.Fatalf("NewStatusSink(StatusBackendLease, ...) = %T, want *leaseSink", sink)
+	}
+
+	status := Status{Status: RunningStatus, Plugins: []PluginStatus{{Node: "n1", Plugin: "p", Status: RunningStatus}}}
+	if err := sink.Publish(context.Background(), status); err != nil {
+		t.Fatalf("Publish returned error: %v", err)
+	}
+
+	cm, err := client.CoreV1().ConfigMaps(namespace).Get(runUID+LeaseConfigMapSuffix, metav1.GetOptions{})
+	if err != nil {
+		t.Fatalf("couldn't get status configmap: %v", err)
+	}
+
+	var got Status
+	if err := json.Unmarshal([]byte(cm.Data[StatusConfigMapKey]), &got); err != nil {
+		t.Fatalf("configmap data isn't valid status json: %v", err)
+	}
+	if got.Status != RunningStatus {
+		t.Errorf("configmap status = %q, want %q", got.Status, RunningStatus)
+	}
+
+	lease, err := client.CoordinationV1().Leases(namespace).Get(runUID, metav1.GetOptions{})
+	if err != nil {
+		t.Fatalf("couldn't get lease: %v", err)
+	}
+	if lease.Spec.HolderIdentity == nil || *lease.Spec.HolderIdentity == "" {
+		t.Error("lease has no HolderIdentity set")
+	}
+	if lease.Spec.RenewTime == nil {
+		t.Error("lease has no RenewTime set")
+	}
+}
+
+// TestLeaseSinkPublishUpdatesExisting checks a second Publish updates the
+// existing ConfigMap and Lease in place rather than erroring on AlreadyExists.
+func TestLeaseSinkPublishUpdatesExisting(t *testing.T) {
+	namespace := "sonobuoy"
+	runUID := "test-run-uid"
+	sink := &leaseSink{client: fake.NewSimpleClientset(), namespace: namespace, runUID: runUID}
+
+	first := Status{Status: RunningStatus}
+	if err := sink.Publish(context.Background(), first); err != nil {
+		t.Fatalf("first Publish returned error: %v", err)
+	}
+
+	second := Status{Status: CompleteStatus}
+	if err := sink.Publish(context.Background(), second); err != nil {
+		t.Fatalf("second Publish returned error: %v", err)
+	}
+
+	got, err := GetStatusFromLease(sink.client, namespace, runUID)
+	if err != nil {
+		t.Fatalf("GetStatusFromLease returned error: %v", err)
+	}
+	if got.Status != CompleteStatus {
+		t.Errorf("status = %q, want %q", got.Status, CompleteStatus)
+	}
+}
+
+// TestGetStatusFromLeaseFallsBackToAnnotation checks that GetStatusFromLease
+// falls back to the pod annotation when no Lease/ConfigMap pair has been
+// published for runUID yet, e.g. a run that's still on the annotation
+// backend, or hasn't published its first lease-backed status yet.
+func TestGetStatusFromLeaseFallsBackToAnnotation(t *testing.T) {
+	namespace := "sonobuoy"
+	runUID := "test-run-uid"
+	annotationStatus := Status{Status: CompleteStatus, Plugins: []PluginStatus{{Node: "n1", Plugin: "e2e", Status: CompleteStatus}}}
+	annotation, err := json.Marshal(annotationStatus)
+	if err != nil {
+		t.Fatalf("couldn't marshal annotation status: %v", err)
+	}
+
+	client := fake.NewSimpleClientset(&v1.Pod{
+		ObjectMeta: metav1.ObjectMeta{
+			Name:      DefaultStatusPodName,
+			Namespace: namespace,
+			Labels:    map[string]string{"run": "sonobuoy-master"},
+			Annotations: map[string]string{
+				StatusAnnotationName: string(annotation),
+			},
+		},
+	})
+
+	got, err := GetStatusFromLease(client, namespace, runUID)
+	if err != nil {
+		t.Fatalf("GetStatusFromLease returned error: %v", err)
+	}
+	if got.Status != CompleteStatus {
+		t.Errorf("status = %q, want %q", got.Status, CompleteStatus)
+	}
+}
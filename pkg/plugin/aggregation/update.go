@@ -17,16 +17,18 @@ limitations under the License.
 package aggregation
 
 import (
+	"context"
 	"encoding/json"
 	"fmt"
 	"sync"
+	"time"
 
 	"github.com/pkg/errors"
 	"github.com/sirupsen/logrus"
 	v1 "k8s.io/api/core/v1"
 	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
-	"k8s.io/apimachinery/pkg/types"
 	"k8s.io/client-go/kubernetes"
+	"k8s.io/client-go/tools/record"
 
 	"github.com/heptio/sonobuoy/pkg/plugin"
 )
@@ -44,32 +46,57 @@ func (n NoPodWithLabelError) Error() string {
 	return string(n)
 }
 
-// node and name uniquely identify a single plugin result
+// node and name uniquely identify a single plugin result within an updater.
+// MultiUpdater already gives each cluster its own updater (and positionLookup),
+// so a cluster dimension here would be redundant.
 type key struct {
 	node, name string
 }
 
-// updater manages setting the Aggregator annotation with the current status
+// updater manages publishing the current status through a StatusSink.
 type updater struct {
 	sync.RWMutex
 	positionLookup map[key]*PluginStatus
 	status         Status
 	namespace      string
 	client         kubernetes.Interface
+	sink           StatusSink
+	recorder       record.EventRecorder
+	pod            *v1.Pod
+	startTimes     map[key]time.Time
 }
 
-// newUpdater creates an an updater that expects ExpectedResult.
-func newUpdater(expected []plugin.ExpectedResult, namespace string, client kubernetes.Interface) *updater {
+// newUpdater creates an an updater that expects ExpectedResult and publishes
+// status through sink. If sink is nil, it defaults to the legacy
+// annotationSink so existing callers keep working unmodified. It does not
+// emit Events; use newUpdaterWithRecorder for that.
+func newUpdater(expected []plugin.ExpectedResult, namespace string, client kubernetes.Interface, sink StatusSink) *updater {
+	return newUpdaterWithRecorder(expected, namespace, client, sink, nil)
+}
+
+// newUpdaterWithRecorder is newUpdater plus an EventRecorder: every plugin
+// status transition is additionally recorded as a Kubernetes Event against
+// the aggregator pod, with reason PluginRunning/PluginComplete/PluginFailed.
+// recorder may be nil, in which case no Events are emitted.
+func newUpdaterWithRecorder(expected []plugin.ExpectedResult, namespace string, client kubernetes.Interface, sink StatusSink, recorder record.EventRecorder) *updater {
+	if sink == nil {
+		sink = NewStatusSink(StatusBackendAnnotation, client, namespace, "")
+	}
+
 	u := &updater{
 		positionLookup: make(map[key]*PluginStatus),
 		status: Status{
 			Plugins: make([]PluginStatus, len(expected)),
 			Status:  RunningStatus,
 		},
-		namespace: namespace,
-		client:    client,
+		namespace:  namespace,
+		client:     client,
+		sink:       sink,
+		recorder:   recorder,
+		startTimes: make(map[key]time.Time, len(expected)),
 	}
 
+	now := time.Now()
 	for i, result := range expected {
 		u.status.Plugins[i] = PluginStatus{
 			Node:   result.NodeName,
@@ -77,7 +104,9 @@ func newUpdater(expected []plugin.ExpectedResult, namespace string, client kuber
 			Status: RunningStatus,
 		}
 
-		u.positionLookup[expectedToKey(result)] = &u.status.Plugins[i]
+		k := expectedToKey(result)
+		u.positionLookup[k] = &u.status.Plugins[i]
+		u.startTimes[k] = now
 	}
 
 	return u
@@ -97,7 +126,13 @@ func (u *updater) Receive(update *PluginStatus) error {
 		return fmt.Errorf("couldn't find key for %v", k)
 	}
 
+	prev := status.Status
 	status.Status = update.Status
+
+	if u.recorder != nil && prev != update.Status {
+		u.recordTransition(prev, update.Status, status, time.Since(u.startTimes[k]))
+	}
+
 	return u.status.updateStatus()
 }
 
@@ -109,30 +144,22 @@ func (u *updater) Serialize() (string, error) {
 	return string(bytes), errors.Wrap(err, "couldn't marshall status")
 }
 
-// Annotate serialises the status json, then annotates the aggregator pod with the status.
+// Annotate serialises the status json, then publishes it through the
+// updater's StatusSink (the aggregator pod annotation, by default).
+//
+// Deprecated: use Publish, which makes clear that the destination isn't
+// necessarily an annotation any more.
 func (u *updater) Annotate(results map[string]*plugin.Result) error {
+	return u.Publish(context.Background(), results)
+}
+
+// Publish updates status from results and pushes the new snapshot through
+// the updater's StatusSink.
+func (u *updater) Publish(ctx context.Context, results map[string]*plugin.Result) error {
 	u.ReceiveAll(results)
 	u.RLock()
 	defer u.RUnlock()
-	str, err := u.Serialize()
-	if err != nil {
-		return errors.Wrap(err, "couldn't serialize status")
-	}
-
-	patch := GetPatch(str)
-	bytes, err := json.Marshal(patch)
-	if err != nil {
-		return errors.Wrap(err, "couldn't encode patch")
-	}
-
-	// Determine sonobuoy pod name
-	podName, err := GetAggregatorPodName(u.client, u.namespace)
-	if err != nil {
-		return errors.Wrap(err, "failed to get name of the aggregator pod to annotate")
-	}
-
-	_, err = u.client.CoreV1().Pods(u.namespace).Patch(podName, types.MergePatchType, bytes)
-	return errors.Wrap(err, "couldn't patch pod annotation")
+	return errors.Wrap(u.sink.Publish(ctx, u.status), "couldn't publish status")
 }
 
 // TODO (tstclair): Evaluate if this should be exported.
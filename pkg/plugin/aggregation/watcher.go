@@ -0,0 +1,315 @@
+/*
+Copyright 2018 Heptio Inc.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package aggregation
+
+import (
+	"context"
+	"encoding/json"
+
+	"github.com/pkg/errors"
+	v1 "k8s.io/api/core/v1"
+	apimeta "k8s.io/apimachinery/pkg/api/meta"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/fields"
+	"k8s.io/apimachinery/pkg/runtime"
+	"k8s.io/apimachinery/pkg/watch"
+	"k8s.io/client-go/kubernetes"
+	"k8s.io/client-go/tools/cache"
+	watchtools "k8s.io/client-go/tools/watch"
+)
+
+// PluginTransition describes a single plugin's status changing between two
+// StatusWatcher observations. Cluster disambiguates which cluster's plugin
+// this is for multi-cluster runs; it is empty for single-cluster runs.
+type PluginTransition struct {
+	Node    string
+	Plugin  string
+	Cluster string
+	From    string
+	To      string
+}
+
+// StatusEvent is delivered on a StatusWatcher's channel every time the
+// underlying object changes. Transitions is empty on the very first event,
+// since there is no prior snapshot to diff against - that first event instead
+// carries the current snapshot as of when Watch started, so callers watching
+// an already-finished run still see its status.
+type StatusEvent struct {
+	Status      Status
+	Transitions []PluginTransition
+	Err         error
+}
+
+// transitionKey identifies a single plugin across StatusWatcher observations.
+// It includes Cluster, unlike the updater's own key type, because a
+// single StatusWatcher can observe a multi-cluster Status whose Plugins reuse
+// the same node/plugin name across different clusters.
+type transitionKey struct {
+	node, name, cluster string
+}
+
+// StatusWatcher streams Status snapshots for a single run by opening a watch
+// on whichever object backs status publication (the aggregator pod
+// annotation, or the Lease/ConfigMap pair), instead of requiring callers to
+// poll. It is the consumer-side counterpart to StatusSink.
+//
+// `sonobuoy status` and `sonobuoy wait` are meant to replace their polling
+// loops with this, but those CLI commands live in cmd/sonobuoy, which isn't
+// part of this source tree, so that wiring is still outstanding.
+type StatusWatcher struct {
+	client    kubernetes.Interface
+	namespace string
+	backend   StatusBackend
+	runUID    string
+}
+
+// NewStatusWatcher constructs a StatusWatcher for the given backend. runUID
+// is required for StatusBackendLease and ignored for StatusBackendAnnotation.
+func NewStatusWatcher(client kubernetes.Interface, namespace string, backend StatusBackend, runUID string) *StatusWatcher {
+	return &StatusWatcher{
+		client:    client,
+		namespace: namespace,
+		backend:   backend,
+		runUID:    runUID,
+	}
+}
+
+// Watch opens a single watch on the status-carrying object and returns a
+// channel of StatusEvents. The channel is closed when ctx is cancelled or
+// when the underlying watchtools.RetryWatcher stops. The RetryWatcher
+// re-opens the watch from the last seen resourceVersion after an ordinary
+// disconnect, but it does not retry a 410 Gone: that is delivered as an error
+// StatusEvent, the watch then ends, and no re-list is made. The watch
+// requests bookmarks (AllowWatchBookmarks), which run() discards on arrival;
+// they never carry a status object.
+func (w *StatusWatcher) Watch(ctx context.Context) (<-chan StatusEvent, error) {
+	lw, decode, decodeList, err := w.listWatch()
+	if err != nil {
+		return nil, err
+	}
+
+	list, err := lw.List(metav1.ListOptions{})
+	if err != nil {
+		return nil, errors.Wrap(err, "couldn't list initial status object")
+	}
+	resourceVersion, err := listResourceVersion(list)
+	if err != nil {
+		return nil, err
+	}
+
+	// The retry watcher only delivers events after resourceVersion, so a run
+	// that already has status published (including one that's already
+	// finished) would otherwise never produce a single StatusEvent. Decode
+	// whatever's already there as the initial snapshot.
+	initial, err := decodeList(list)
+	if err != nil {
+		initial = nil
+	}
+
+	retryWatcher, err := watchtools.NewRetryWatcher(resourceVersion, lw)
+	if err != nil {
+		return nil, errors.Wrap(err, "couldn't start status watch")
+	}
+
+	out := make(chan StatusEvent)
+	go w.run(ctx, retryWatcher, decode, initial, out)
+	return out, nil
+}
+
+func (w *StatusWatcher) run(ctx context.Context, watcher watch.Interface, decode func(interface{}) (*Status, error), previous *Status, out chan<- StatusEvent) {
+	defer close(out)
+	defer watcher.Stop()
+
+	if previous != nil {
+		if !w.emit(ctx, out, StatusEvent{Status: *previous}) {
+			return
+		}
+	}
+
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case event, ok := <-watcher.ResultChan():
+			if !ok {
+				return
+			}
+			if event.Type == watch.Error {
+				w.emit(ctx, out, StatusEvent{Err: errors.Errorf("status watch error: %v", event.Object)})
+				continue
+			}
+			if event.Type == watch.Bookmark {
+				// Bookmarks only carry an updated resourceVersion, not a
+				// status object worth decoding.
+				continue
+			}
+
+			status, err := decode(event.Object)
+			if err != nil {
+				w.emit(ctx, out, StatusEvent{Err: errors.Wrap(err, "couldn't decode status")})
+				continue
+			}
+
+			transitions := diffStatus(previous, status)
+			previous = status
+			w.emit(ctx, out, StatusEvent{Status: *status, Transitions: transitions})
+		}
+	}
+}
+
+func listResourceVersion(list runtime.Object) (string, error) {
+	accessor, err := apimeta.ListAccessor(list)
+	if err != nil {
+		return "", errors.Wrap(err, "couldn't get resourceVersion of initial list")
+	}
+	return accessor.GetResourceVersion(), nil
+}
+
+// emit delivers e on out, returning false if ctx was cancelled first.
+func (w *StatusWatcher) emit(ctx context.Context, out chan<- StatusEvent, e StatusEvent) bool {
+	select {
+	case out <- e:
+		return true
+	case <-ctx.Done():
+		return false
+	}
+}
+
+// listWatch returns a ListerWatcher for the object this watcher's backend
+// publishes status through, a decode func that turns a single watched object
+// into a Status, and a decodeList func that turns the result of lw.List into
+// a Status (used to synthesize the initial StatusEvent).
+func (w *StatusWatcher) listWatch() (lw *cache.ListWatch, decode func(interface{}) (*Status, error), decodeList func(runtime.Object) (*Status, error), err error) {
+	switch w.backend {
+	case StatusBackendLease:
+		name := w.runUID + LeaseConfigMapSuffix
+		selector := fields.OneTermEqualSelector("metadata.name", name).String()
+		lw = &cache.ListWatch{
+			ListFunc: func(options metav1.ListOptions) (runtime.Object, error) {
+				options.FieldSelector = selector
+				return w.client.CoreV1().ConfigMaps(w.namespace).List(options)
+			},
+			WatchFunc: func(options metav1.ListOptions) (watch.Interface, error) {
+				options.FieldSelector = selector
+				options.AllowWatchBookmarks = true
+				return w.client.CoreV1().ConfigMaps(w.namespace).Watch(options)
+			},
+		}
+		return lw, decodeConfigMapStatus, decodeConfigMapListStatus, nil
+
+	case StatusBackendAnnotation:
+		podName, err := GetAggregatorPodName(w.client, w.namespace)
+		if err != nil {
+			return nil, nil, nil, errors.Wrap(err, "failed to get name of the aggregator pod to watch")
+		}
+		selector := fields.OneTermEqualSelector("metadata.name", podName).String()
+		lw = &cache.ListWatch{
+			ListFunc: func(options metav1.ListOptions) (runtime.Object, error) {
+				options.FieldSelector = selector
+				return w.client.CoreV1().Pods(w.namespace).List(options)
+			},
+			WatchFunc: func(options metav1.ListOptions) (watch.Interface, error) {
+				options.FieldSelector = selector
+				options.AllowWatchBookmarks = true
+				return w.client.CoreV1().Pods(w.namespace).Watch(options)
+			},
+		}
+		return lw, decodePodStatus, decodePodListStatus, nil
+
+	default:
+		return nil, nil, nil, errors.Errorf("unknown status backend %q", w.backend)
+	}
+}
+
+func decodeConfigMapStatus(obj interface{}) (*Status, error) {
+	cm, ok := obj.(*v1.ConfigMap)
+	if !ok {
+		return nil, errors.Errorf("expected *v1.ConfigMap, got %T", obj)
+	}
+	status := &Status{}
+	if err := json.Unmarshal([]byte(cm.Data[StatusConfigMapKey]), status); err != nil {
+		return nil, errors.Wrap(err, "couldn't decode status configmap")
+	}
+	return status, nil
+}
+
+func decodePodStatus(obj interface{}) (*Status, error) {
+	pod, ok := obj.(*v1.Pod)
+	if !ok {
+		return nil, errors.Errorf("expected *v1.Pod, got %T", obj)
+	}
+	annotation, ok := pod.Annotations[StatusAnnotationName]
+	if !ok {
+		return nil, errors.Errorf("pod %q has no %q annotation", pod.GetName(), StatusAnnotationName)
+	}
+	status := &Status{}
+	if err := json.Unmarshal([]byte(annotation), status); err != nil {
+		return nil, errors.Wrap(err, "couldn't decode status annotation")
+	}
+	return status, nil
+}
+
+func decodeConfigMapListStatus(list runtime.Object) (*Status, error) {
+	cmList, ok := list.(*v1.ConfigMapList)
+	if !ok {
+		return nil, errors.Errorf("expected *v1.ConfigMapList, got %T", list)
+	}
+	if len(cmList.Items) == 0 {
+		return nil, errors.New("no status configmap published yet")
+	}
+	return decodeConfigMapStatus(&cmList.Items[0])
+}
+
+func decodePodListStatus(list runtime.Object) (*Status, error) {
+	podList, ok := list.(*v1.PodList)
+	if !ok {
+		return nil, errors.Errorf("expected *v1.PodList, got %T", list)
+	}
+	if len(podList.Items) == 0 {
+		return nil, errors.New("no aggregator pod found to read status from")
+	}
+	return decodePodStatus(&podList.Items[0])
+}
+
+// diffStatus compares two Status snapshots and returns the plugins whose
+// Status field changed. prev may be nil, in which case no transitions are
+// reported (there's nothing to diff the first observation against).
+func diffStatus(prev, next *Status) []PluginTransition {
+	if prev == nil {
+		return nil
+	}
+
+	prevStatus := make(map[transitionKey]string, len(prev.Plugins))
+	for _, p := range prev.Plugins {
+		prevStatus[transitionKey{node: p.Node, name: p.Plugin, cluster: p.Cluster}] = p.Status
+	}
+
+	var transitions []PluginTransition
+	for _, p := range next.Plugins {
+		k := transitionKey{node: p.Node, name: p.Plugin, cluster: p.Cluster}
+		if old, ok := prevStatus[k]; !ok || old != p.Status {
+			transitions = append(transitions, PluginTransition{
+				Node:    p.Node,
+				Plugin:  p.Plugin,
+				Cluster: p.Cluster,
+				From:    old,
+				To:      p.Status,
+			})
+		}
+	}
+	return transitions
+}
@@ -0,0 +1,161 @@
+/*
+Copyright 2018 Heptio Inc.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package aggregation
+
+import (
+	"context"
+	"encoding/json"
+	"testing"
+	"time"
+
+	v1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/client-go/kubernetes/fake"
+)
+
+// TestStatusWatcherEmitsInitialSnapshot ensures Watch delivers a StatusEvent
+// for whatever status already exists at watch-start, rather than only
+// delivering events for changes that happen after the watch opens. Without
+// this, watching an already-finished run would never produce anything.
+func TestStatusWatcherEmitsInitialSnapshot(t *testing.T) {
+	namespace := "sonobuoy"
+	initialStatus := Status{
+		Status:  CompleteStatus,
+		Plugins: []PluginStatus{{Node: "n1", Plugin: "e2e", Status: CompleteStatus}},
+	}
+	annotation, err := json.Marshal(initialStatus)
+	if err != nil {
+		t.Fatalf("couldn't marshal initial status: %v", err)
+	}
+
+	client := fake.NewSimpleClientset(&v1.Pod{
+		ObjectMeta: metav1.ObjectMeta{
+			Name:      DefaultStatusPodName,
+			Namespace: namespace,
+			Labels:    map[string]string{"run": "sonobuoy-master"},
+			Annotations: map[string]string{
+				StatusAnnotationName: string(annotation),
+			},
+		},
+	})
+
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	watcher := NewStatusWatcher(client, namespace, StatusBackendAnnotation, "")
+	events, err := watcher.Watch(ctx)
+	if err != nil {
+		t.Fatalf("Watch returned error: %v", err)
+	}
+
+	select {
+	case event, ok := <-events:
+		if !ok {
+			t.Fatal("events channel closed before delivering the initial snapshot")
+		}
+		if event.Err != nil {
+			t.Fatalf("initial event carried error: %v", event.Err)
+		}
+		if event.Status.Status != CompleteStatus {
+			t.Errorf("initial event status = %q, want %q", event.Status.Status, CompleteStatus)
+		}
+		if len(event.Transitions) != 0 {
+			t.Errorf("initial event should have no transitions, got %v", event.Transitions)
+		}
+	case <-time.After(5 * time.Second):
+		t.Fatal("timed out waiting for initial StatusEvent")
+	}
+}
+
+// TestStatusWatcherEmitsTransitionOnModify pushes a real update through the
+// fake clientset (which the fake watch machinery turns into a
+// watch.Modified event) and checks Watch decodes it and reports the plugin's
+// transition via diffStatus, end-to-end.
+func TestStatusWatcherEmitsTransitionOnModify(t *testing.T) {
+	namespace := "sonobuoy"
+	initialStatus := Status{
+		Status:  RunningStatus,
+		Plugins: []PluginStatus{{Node: "n1", Plugin: "e2e", Status: RunningStatus}},
+	}
+	annotation, err := json.Marshal(initialStatus)
+	if err != nil {
+		t.Fatalf("couldn't marshal initial status: %v", err)
+	}
+
+	pod := &v1.Pod{
+		ObjectMeta: metav1.ObjectMeta{
+			Name:      DefaultStatusPodName,
+			Namespace: namespace,
+			Labels:    map[string]string{"run": "sonobuoy-master"},
+			Annotations: map[string]string{
+				StatusAnnotationName: string(annotation),
+			},
+		},
+	}
+	client := fake.NewSimpleClientset(pod)
+
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	watcher := NewStatusWatcher(client, namespace, StatusBackendAnnotation, "")
+	events, err := watcher.Watch(ctx)
+	if err != nil {
+		t.Fatalf("Watch returned error: %v", err)
+	}
+
+	select {
+	case event, ok := <-events:
+		if !ok || event.Err != nil {
+			t.Fatalf("didn't get initial snapshot: ok=%v err=%v", ok, event.Err)
+		}
+	case <-time.After(5 * time.Second):
+		t.Fatal("timed out waiting for initial StatusEvent")
+	}
+
+	completeStatus := Status{
+		Status:  CompleteStatus,
+		Plugins: []PluginStatus{{Node: "n1", Plugin: "e2e", Status: CompleteStatus}},
+	}
+	completeAnnotation, err := json.Marshal(completeStatus)
+	if err != nil {
+		t.Fatalf("couldn't marshal complete status: %v", err)
+	}
+	pod.Annotations[StatusAnnotationName] = string(completeAnnotation)
+	if _, err := client.CoreV1().Pods(namespace).Update(pod); err != nil {
+		t.Fatalf("couldn't update pod: %v", err)
+	}
+
+	select {
+	case event, ok := <-events:
+		if !ok {
+			t.Fatal("events channel closed before delivering the transition")
+		}
+		if event.Err != nil {
+			t.Fatalf("transition event carried error: %v", event.Err)
+		}
+		if len(event.Transitions) != 1 {
+			t.Fatalf("got %d transitions, want 1: %+v", len(event.Transitions), event.Transitions)
+		}
+		got := event.Transitions[0]
+		want := PluginTransition{Node: "n1", Plugin: "e2e", From: RunningStatus, To: CompleteStatus}
+		if got != want {
+			t.Errorf("transition = %+v, want %+v", got, want)
+		}
+	case <-time.After(5 * time.Second):
+		t.Fatal("timed out waiting for transition StatusEvent")
+	}
+}